@@ -0,0 +1,24 @@
+package mailyak
+
+// bodyPart wraps one of the body alternatives (HTML or plain text),
+// allowing it to be set directly or written to incrementally, e.g. from a
+// template.
+type bodyPart struct {
+	buf *[]byte
+}
+
+// Set replaces the body part's content with s.
+func (p *bodyPart) Set(s string) {
+	*p.buf = []byte(s)
+}
+
+// Write implements io.Writer, appending b to the body part's content.
+func (p *bodyPart) Write(b []byte) (int, error) {
+	*p.buf = append(*p.buf, b...)
+	return len(b), nil
+}
+
+// String returns the body part's current content.
+func (p *bodyPart) String() string {
+	return string(*p.buf)
+}
@@ -0,0 +1,230 @@
+package mailyak
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Canonicalization selects an RFC 6376 header/body canonicalization
+// algorithm.
+type Canonicalization string
+
+// Supported canonicalization algorithms, applied to both the header and the
+// body.
+const (
+	// CanonicalizationSimple tolerates almost no modification in transit.
+	CanonicalizationSimple Canonicalization = "simple"
+	// CanonicalizationRelaxed tolerates common header/body rewriting done by
+	// intermediate MTAs, such as whitespace collapsing.
+	CanonicalizationRelaxed Canonicalization = "relaxed"
+)
+
+// Signer signs the canonicalized headers and body of an outgoing message,
+// returning the literal DKIM-Signature header line to prepend, e.g.
+// "DKIM-Signature: v=1; a=rsa-sha256; ...; b=...". headers and body have
+// already been canonicalized per canon, which the Signer must also report
+// in its own c= tag, since mailyak (not the Signer) decides which
+// Canonicalization was actually applied (see SetDKIMCanonicalization).
+type Signer interface {
+	Sign(headers, body []byte, canon Canonicalization) (headerLine string, err error)
+}
+
+// WithSigner configures m to sign every message with s before it is sent
+// or streamed, prepending the resulting DKIM-Signature header. Passing a
+// nil Signer disables signing.
+func (m *MailYak) WithSigner(s Signer) {
+	m.signer = s
+}
+
+// SetDKIMCanonicalization sets the RFC 6376 canonicalization algorithm used
+// to prepare the header and body for signing. The default, used when
+// SetDKIMCanonicalization is never called, is CanonicalizationSimple.
+func (m *MailYak) SetDKIMCanonicalization(c Canonicalization) {
+	m.dkimCanon = c
+}
+
+// sign splits raw, a fully built message, into its header block and body,
+// canonicalizes both per m.dkimCanon, and prepends the DKIM-Signature
+// header line returned by m.signer to raw.
+func (m *MailYak) sign(raw []byte) ([]byte, error) {
+	sep := []byte("\r\n\r\n")
+	i := bytes.Index(raw, sep)
+	if i < 0 {
+		return nil, fmt.Errorf("mailyak: signing message: no header/body separator found")
+	}
+
+	canon := m.dkimCanon
+	if canon == "" {
+		canon = CanonicalizationSimple
+	}
+
+	headers := canonicalizeHeaders(raw[:i], canon)
+	body := canonicalizeBody(raw[i+len(sep):], canon)
+
+	headerLine, err := m.signer.Sign(headers, body, canon)
+	if err != nil {
+		return nil, fmt.Errorf("mailyak: signing message: %w", err)
+	}
+
+	signed := make([]byte, 0, len(headerLine)+2+len(raw))
+	signed = append(signed, []byte(headerLine)...)
+	signed = append(signed, "\r\n"...)
+	signed = append(signed, raw...)
+
+	return signed, nil
+}
+
+var dkimWSRun = regexp.MustCompile(`[ \t]+`)
+
+// canonicalizeHeaders canonicalizes the raw header block (not including the
+// blank line separating it from the body) per c. Header fields are handled
+// one physical line at a time: folded fields (such as the Content-Type
+// header mailyak writes) are not unfolded, and a continuation line without
+// a colon is left unchanged even under CanonicalizationRelaxed.
+func canonicalizeHeaders(raw []byte, c Canonicalization) []byte {
+	lines := strings.Split(strings.TrimRight(string(raw), "\r\n"), "\r\n")
+
+	if c == CanonicalizationRelaxed {
+		for i, l := range lines {
+			idx := strings.Index(l, ":")
+			if idx < 0 {
+				continue
+			}
+
+			name := strings.ToLower(strings.TrimSpace(l[:idx]))
+			val := strings.TrimSpace(dkimWSRun.ReplaceAllString(l[idx+1:], " "))
+			lines[i] = name + ":" + val
+		}
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// canonicalizeBody canonicalizes the message body per c: trailing empty
+// lines are removed, and for CanonicalizationRelaxed, whitespace runs
+// within each line are collapsed to a single space and trailing whitespace
+// is removed. A non-empty result always ends with a single CRLF. An empty
+// body canonicalizes to a single CRLF for CanonicalizationSimple, and to
+// nothing for CanonicalizationRelaxed.
+func canonicalizeBody(raw []byte, c Canonicalization) []byte {
+	lines := strings.Split(string(raw), "\r\n")
+
+	if c == CanonicalizationRelaxed {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(dkimWSRun.ReplaceAllString(l, " "), " \t")
+		}
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if c == CanonicalizationSimple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// RSASigner is a Signer producing an RSA-SHA256 DKIM-Signature, per RFC
+// 6376 section 3.3.
+type RSASigner struct {
+	// PrivateKey signs the message.
+	PrivateKey *rsa.PrivateKey
+	// Selector and Domain identify the public key used to verify the
+	// signature, published in DNS at "<Selector>._domainkey.<Domain>".
+	Selector, Domain string
+	// Headers lists, by name, the header fields to include in the
+	// signature. Defaults to {"From", "To", "Subject"} if nil. Names are
+	// matched case-insensitively against the canonicalized header block.
+	Headers []string
+}
+
+// NewRSASigner returns an RSASigner that signs with priv, identifying the
+// public key via selector and domain.
+func NewRSASigner(priv *rsa.PrivateKey, selector, domain string) *RSASigner {
+	return &RSASigner{PrivateKey: priv, Selector: selector, Domain: domain}
+}
+
+func (s *RSASigner) headers() []string {
+	if len(s.Headers) > 0 {
+		return s.Headers
+	}
+	return []string{"From", "To", "Subject"}
+}
+
+// Sign implements Signer, computing bh= over body and b= over the
+// canonicalized headers named by s.Headers plus the DKIM-Signature header
+// line itself (with an empty b= tag, and canonicalized per canon like any
+// other signed header), per RFC 6376 section 3.7.
+func (s *RSASigner) Sign(headers, body []byte, canon Canonicalization) (string, error) {
+	bodyHash := sha256.Sum256(body)
+	bh := base64.StdEncoding.EncodeToString(bodyHash[:])
+
+	names := s.headers()
+	signedHeaders := selectHeaders(headers, names)
+
+	lowerNames := make([]string, len(names))
+	for i, n := range names {
+		lowerNames[i] = strings.ToLower(n)
+	}
+
+	dkimHeader := fmt.Sprintf(
+		"DKIM-Signature: v=1; a=rsa-sha256; c=%s/%s; d=%s; s=%s; h=%s; bh=%s; b=",
+		canon, canon, s.Domain, s.Selector, strings.Join(lowerNames, ":"), bh,
+	)
+
+	canonDkimHeader := bytes.TrimSuffix(canonicalizeHeaders([]byte(dkimHeader), canon), []byte("\r\n"))
+
+	signedData := append(append([]byte{}, signedHeaders...), canonDkimHeader...)
+
+	digest := sha256.Sum256(signedData)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return dkimHeader + base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// selectHeaders returns the canonicalized lines of headers whose field
+// names match names, in the order given by names, joined by CRLF with a
+// trailing CRLF. Unmatched names are silently skipped.
+//
+// Per RFC 6376 section 5.4.2, when a field name is repeated (e.g. mailyak
+// writes one To line per recipient), the bottom-most instance is selected,
+// not the first.
+func selectHeaders(headers []byte, names []string) []byte {
+	lines := strings.Split(strings.TrimRight(string(headers), "\r\n"), "\r\n")
+
+	var out []string
+	for _, name := range names {
+		for i := len(lines) - 1; i >= 0; i-- {
+			l := lines[i]
+			idx := strings.Index(l, ":")
+			if idx < 0 {
+				continue
+			}
+			if strings.EqualFold(strings.TrimSpace(l[:idx]), name) {
+				out = append(out, l)
+				break
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(out, "\r\n") + "\r\n")
+}
@@ -0,0 +1,196 @@
+package mailyak
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestMailYakWithSigner ensures a message signed via WithSigner gets a
+// DKIM-Signature header whose b= signature verifies against the
+// canonicalized headers and body mailyak produced, for both supported
+// canonicalization algorithms.
+func TestMailYakWithSigner(t *testing.T) {
+	tests := []struct {
+		name  string
+		canon Canonicalization
+	}{
+		{"default simple", ""},
+		{"relaxed", CanonicalizationRelaxed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priv, err := rsa.GenerateKey(rand.Reader, 1024)
+			if err != nil {
+				t.Fatalf("GenerateKey() error = %v", err)
+			}
+
+			m := New("", nil)
+			m.From("dom@itsallbroken.com")
+			m.To("test@itsallbroken.com")
+			m.Subject("Test")
+			m.Plain().Set("Hello")
+			m.WithSigner(NewRSASigner(priv, "selector1", "itsallbroken.com"))
+			if tt.canon != "" {
+				m.SetDKIMCanonicalization(tt.canon)
+			}
+
+			canon := tt.canon
+			if canon == "" {
+				canon = CanonicalizationSimple
+			}
+
+			buf, err := m.MimeBuf()
+			if err != nil {
+				t.Fatalf("MimeBuf() error = %v", err)
+			}
+
+			raw := buf.String()
+			if !strings.HasPrefix(raw, "DKIM-Signature: ") {
+				t.Fatalf("message doesn't start with a DKIM-Signature header:\n%s", raw)
+			}
+
+			sigLine, rest, ok := strings.Cut(raw, "\r\n")
+			if !ok {
+				t.Fatalf("couldn't split DKIM-Signature header from rest of message")
+			}
+
+			wantC := fmt.Sprintf("c=%s/%s", canon, canon)
+			if !strings.Contains(sigLine, wantC) {
+				t.Errorf("DKIM-Signature header missing %q: %s", wantC, sigLine)
+			}
+
+			bIdx := strings.Index(sigLine, "b=")
+			if bIdx < 0 {
+				t.Fatalf("DKIM-Signature header has no b= tag: %s", sigLine)
+			}
+			sigB64 := sigLine[bIdx+2:]
+
+			sig, err := base64.StdEncoding.DecodeString(sigB64)
+			if err != nil {
+				t.Fatalf("decoding b= tag: %v", err)
+			}
+
+			headerNoSig := sigLine[:bIdx+2]
+			canonDkimHeader := bytes.TrimSuffix(canonicalizeHeaders([]byte(headerNoSig), canon), []byte("\r\n"))
+
+			headers := canonicalizeHeaders([]byte(strings.SplitN(rest, "\r\n\r\n", 2)[0]), canon)
+			signedHeaders := selectHeaders(headers, []string{"From", "To", "Subject"})
+			signedData := append(append([]byte{}, signedHeaders...), canonDkimHeader...)
+			digest := sha256.Sum256(signedData)
+
+			if err := rsa.VerifyPKCS1v15(&priv.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+				t.Errorf("signature does not verify: %v", err)
+			}
+		})
+	}
+}
+
+// TestMailYakWithSigner_MultipleToAndCc ensures the DKIM signature verifies
+// when there's more than one To/Cc address, and so more than one To/Cc
+// header line to choose from. The expected signed data is computed
+// independently of selectHeaders, picking the bottom-most occurrence of
+// each header name the way RFC 6376 section 5.4.2 requires a verifier to,
+// so this catches selectHeaders picking the wrong occurrence even if
+// production code and this test happened to agree on a buggy helper.
+func TestMailYakWithSigner_MultipleToAndCc(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	m := New("", nil)
+	m.From("dom@itsallbroken.com")
+	m.To("first@itsallbroken.com", "second@itsallbroken.com")
+	m.Cc("third@itsallbroken.com", "fourth@itsallbroken.com")
+	m.Subject("Test")
+	m.Plain().Set("Hello")
+	m.WithSigner(NewRSASigner(priv, "selector1", "itsallbroken.com"))
+
+	buf, err := m.MimeBuf()
+	if err != nil {
+		t.Fatalf("MimeBuf() error = %v", err)
+	}
+
+	raw := buf.String()
+	sigLine, rest, ok := strings.Cut(raw, "\r\n")
+	if !ok {
+		t.Fatalf("couldn't split DKIM-Signature header from rest of message")
+	}
+
+	bIdx := strings.Index(sigLine, "b=")
+	if bIdx < 0 {
+		t.Fatalf("DKIM-Signature header has no b= tag: %s", sigLine)
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigLine[bIdx+2:])
+	if err != nil {
+		t.Fatalf("decoding b= tag: %v", err)
+	}
+	headerNoSig := sigLine[:bIdx+2]
+
+	rawHeaders := strings.SplitN(rest, "\r\n\r\n", 2)[0]
+	lines := strings.Split(rawHeaders, "\r\n")
+
+	var bottomMost []string
+	for _, name := range []string{"From", "To", "Subject"} {
+		for i := len(lines) - 1; i >= 0; i-- {
+			if prefix := name + ": "; strings.HasPrefix(lines[i], prefix) {
+				bottomMost = append(bottomMost, lines[i])
+				break
+			}
+		}
+	}
+	if len(bottomMost) != 3 || bottomMost[1] != "To: second@itsallbroken.com" {
+		t.Fatalf("test setup: bottom-most headers = %v, want To line for second@itsallbroken.com", bottomMost)
+	}
+
+	signedHeaders := []byte(strings.Join(bottomMost, "\r\n") + "\r\n")
+	signedData := append(append([]byte{}, signedHeaders...), []byte(headerNoSig)...)
+	digest := sha256.Sum256(signedData)
+
+	if err := rsa.VerifyPKCS1v15(&priv.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("signature does not verify against bottom-most To/From/Subject lines: %v", err)
+	}
+}
+
+// TestCanonicalizeBody ensures canonicalizeBody follows RFC 6376 3.4.3/3.4.4:
+// trailing empty lines are stripped, relaxed also collapses whitespace.
+func TestCanonicalizeBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		c    Canonicalization
+		want string
+	}{
+		{"simple trims trailing blank lines", "Hi\r\n\r\n\r\n", CanonicalizationSimple, "Hi\r\n"},
+		{"simple empty body", "", CanonicalizationSimple, "\r\n"},
+		{"relaxed collapses whitespace", "Hi   there  \r\n\r\n", CanonicalizationRelaxed, "Hi there\r\n"},
+	}
+
+	for _, tt := range tests {
+		got := string(canonicalizeBody([]byte(tt.body), tt.c))
+		if got != tt.want {
+			t.Errorf("%q. canonicalizeBody() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestCanonicalizeHeaders ensures relaxed canonicalization lowercases
+// header names and collapses whitespace around the value.
+func TestCanonicalizeHeaders(t *testing.T) {
+	raw := "Subject:  Hello   World  \r\nFrom: dom@itsallbroken.com\r\n"
+
+	got := string(canonicalizeHeaders([]byte(raw), CanonicalizationRelaxed))
+	want := "subject:Hello World\r\nfrom:dom@itsallbroken.com\r\n"
+
+	if got != want {
+		t.Errorf("canonicalizeHeaders() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,269 @@
+// Package mailyak implements a simple interface for building and sending
+// MIME email messages over SMTP.
+package mailyak
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/smtp"
+	"regexp"
+)
+
+// MailYak represents an email.
+type MailYak struct {
+	html  []byte
+	plain []byte
+
+	toAddrs  []string
+	ccAddrs  []string
+	bccAddrs []string
+	fromAddr string
+	fromName string
+	replyTo  string
+	subject  string
+
+	attachments []attachment
+	inlines     []inlineAttachment
+
+	textEncoding Encoding
+
+	signer    Signer
+	dkimCanon Canonicalization
+
+	auth smtp.Auth
+	host string
+
+	trimRegex *regexp.Regexp
+}
+
+// New returns an instance of MailYak using host as the SMTP server, and
+// authenticating with auth where required.
+//
+// host must include the port number (host:port form).
+func New(host string, auth smtp.Auth) *MailYak {
+	return &MailYak{
+		host:      host,
+		auth:      auth,
+		trimRegex: regexp.MustCompile("\r?\n"),
+	}
+}
+
+// HTML returns a bodyPart for the HTML section of the email.
+func (m *MailYak) HTML() *bodyPart {
+	return &bodyPart{buf: &m.html}
+}
+
+// Plain returns a bodyPart for the plain text section of the email.
+func (m *MailYak) Plain() *bodyPart {
+	return &bodyPart{buf: &m.plain}
+}
+
+// To sets the To address(es).
+func (m *MailYak) To(addrs ...string) {
+	m.toAddrs = addrs
+}
+
+// Cc sets the Cc address(es).
+func (m *MailYak) Cc(addrs ...string) {
+	m.ccAddrs = addrs
+}
+
+// Bcc sets the Bcc address(es).
+func (m *MailYak) Bcc(addrs ...string) {
+	m.bccAddrs = addrs
+}
+
+// Subject sets the Subject line of the email.
+func (m *MailYak) Subject(sub string) {
+	m.subject = sub
+}
+
+// From sets the From email address.
+func (m *MailYak) From(addr string) {
+	m.fromAddr = addr
+}
+
+// FromName sets the From name to be used alongside the From address.
+func (m *MailYak) FromName(name string) {
+	m.fromName = name
+}
+
+// ReplyTo sets the Reply-To email address.
+func (m *MailYak) ReplyTo(addr string) {
+	m.replyTo = addr
+}
+
+// SetEncoding sets the Content-Transfer-Encoding used for the plain text
+// and HTML body parts. The default, used when SetEncoding is never called,
+// is EncodingQuotedPrintable.
+func (m *MailYak) SetEncoding(e Encoding) {
+	m.textEncoding = e
+}
+
+// Attach adds a new attachment to the email, sourcing the content from r.
+//
+// r is not read until the email is sent, and must remain valid until then.
+func (m *MailYak) Attach(filename string, r io.Reader) {
+	m.attachments = append(m.attachments, readerAttachment{filename: filename, reader: r})
+}
+
+// AttachWithFunc adds a new attachment to the email, streaming its content
+// from fn directly into the MIME writer when the message is built. Unlike
+// Attach, the content is never buffered in an io.Reader, making this
+// suitable for large attachments generated on the fly.
+//
+// fn is not called until the email is sent.
+func (m *MailYak) AttachWithFunc(filename string, contentType string, fn func(io.Writer) error) {
+	m.attachments = append(m.attachments, funcAttachment{filename: filename, contentType: contentType, fn: fn})
+}
+
+// AttachInline adds a new inline attachment to the email, sourcing the
+// content from r and identifying it with cid, so an HTML alternative can
+// reference it as an embedded image via `<img src="cid:<cid>">`.
+//
+// r is not read until the email is sent, and must remain valid until then.
+func (m *MailYak) AttachInline(cid, filename string, r io.Reader) {
+	m.inlines = append(m.inlines, inlineAttachment{
+		cid:        cid,
+		attachment: readerAttachment{filename: filename, reader: r},
+	})
+}
+
+// AttachInlineWithFunc adds a new inline attachment to the email, streaming
+// its content from fn directly into the MIME writer when the message is
+// built, rather than buffering it in memory ahead of time. See AttachInline
+// for how cid is used.
+//
+// fn is not called until the email is sent.
+func (m *MailYak) AttachInlineWithFunc(cid, filename, contentType string, fn func(io.Writer) error) {
+	m.inlines = append(m.inlines, inlineAttachment{
+		cid:        cid,
+		attachment: funcAttachment{filename: filename, contentType: contentType, fn: fn},
+	})
+}
+
+// Send sends the email via the SMTP server configured in New().
+func (m *MailYak) Send() error {
+	buf := &bytes.Buffer{}
+	if err := m.MimeStream(buf); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.host, m.auth, m.fromAddr, m.recipients(), buf.Bytes())
+}
+
+// recipients returns the de-duplicated union of the To, Cc and Bcc
+// addresses, suitable for use as the RCPT TO list when sending.
+func (m *MailYak) recipients() []string {
+	addrs := make([]string, 0, len(m.toAddrs)+len(m.ccAddrs)+len(m.bccAddrs))
+	seen := make(map[string]bool, cap(addrs))
+
+	for _, addr := range append(append(append([]string{}, m.toAddrs...), m.ccAddrs...), m.bccAddrs...) {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// MimeBuf returns the buffer containing all the RAW MIME data.
+//
+// MimeBuf is typically used when a Writer is required to write email data to
+// a file, HTTP request, or otherwise. Callers writing to an io.Writer
+// directly, e.g. an os.File or an HTTP request body, should use MimeStream
+// instead, which doesn't buffer the message in memory unless a Signer is
+// configured (see MimeStream).
+func (m *MailYak) MimeBuf() (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	if err := m.MimeStream(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// String returns a redacted description of the email state, typically for
+// use in logging or debugging.
+func (m *MailYak) String() string {
+	var attachNames string
+	for i, a := range m.attachments {
+		if i > 0 {
+			attachNames += ", "
+		}
+		attachNames += a.name()
+	}
+
+	return fmt.Sprintf(
+		"-- Email --\nFrom: %s\nFrom Name: %s\nTo: %v\nReply-To: %s\nSubject: %s\nAttachments: [%s]\n-- Email --\n",
+		m.fromAddr,
+		m.fromName,
+		m.toAddrs,
+		m.replyTo,
+		m.subject,
+		attachNames,
+	)
+}
+
+// MimeStream writes the full RAW MIME message directly to w, generating
+// fresh boundaries and handing off to buildMimeWithBoundaries to build the
+// actual message.
+//
+// Unlike MimeBuf, MimeStream never buffers the message in memory: content
+// from Attach/AttachInline and their WithFunc variants is streamed straight
+// from its source (an io.Reader or a callback) through base64 encoding and
+// into w. This keeps peak memory usage low for messages with large
+// attachments when w is, for example, an os.File or the body of an HTTP
+// request.
+//
+// If a Signer is configured via WithSigner, this guarantee doesn't hold:
+// producing a DKIM-Signature header requires hashing the entire
+// canonicalized message first, so MimeStream buffers the message in memory
+// to sign it before writing the result to w.
+func (m *MailYak) MimeStream(w io.Writer) error {
+	mixedBoundary, err := generateBoundary()
+	if err != nil {
+		return err
+	}
+
+	relatedBoundary, err := generateBoundary()
+	if err != nil {
+		return err
+	}
+
+	altBoundary, err := generateBoundary()
+	if err != nil {
+		return err
+	}
+
+	if m.signer == nil {
+		return m.buildMimeWithBoundaries(w, mixedBoundary, relatedBoundary, altBoundary)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := m.buildMimeWithBoundaries(buf, mixedBoundary, relatedBoundary, altBoundary); err != nil {
+		return err
+	}
+
+	signed, err := m.sign(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(signed)
+	return err
+}
+
+// generateBoundary returns a random hex string, suitable for use as a MIME
+// boundary.
+func generateBoundary() (string, error) {
+	buf := make([]byte, 30)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", buf), nil
+}
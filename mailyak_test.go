@@ -0,0 +1,157 @@
+package mailyak
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestMailYakRecipients ensures recipients returns the de-duplicated union
+// of To, Cc and Bcc addresses, in that order.
+func TestMailYakRecipients(t *testing.T) {
+	tests := []struct {
+		name     string
+		toAddrs  []string
+		ccAddrs  []string
+		bccAddrs []string
+		want     []string
+	}{
+		{
+			"To only",
+			[]string{"to@itsallbroken.com"},
+			nil,
+			nil,
+			[]string{"to@itsallbroken.com"},
+		},
+		{
+			"To, Cc and Bcc",
+			[]string{"to@itsallbroken.com"},
+			[]string{"cc@itsallbroken.com"},
+			[]string{"bcc@itsallbroken.com"},
+			[]string{"to@itsallbroken.com", "cc@itsallbroken.com", "bcc@itsallbroken.com"},
+		},
+		{
+			"duplicate address across To and Cc",
+			[]string{"to@itsallbroken.com"},
+			[]string{"to@itsallbroken.com", "cc@itsallbroken.com"},
+			nil,
+			[]string{"to@itsallbroken.com", "cc@itsallbroken.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		m := MailYak{
+			toAddrs:  tt.toAddrs,
+			ccAddrs:  tt.ccAddrs,
+			bccAddrs: tt.bccAddrs,
+		}
+
+		if got := m.recipients(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. MailYak.recipients() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestMailYakAttachWithFunc ensures AttachWithFunc streams fn's output into
+// the MIME writer without ever storing it in an io.Reader.
+func TestMailYakAttachWithFunc(t *testing.T) {
+	m := &MailYak{}
+
+	called := false
+	m.AttachWithFunc("report.csv", "text/csv", func(w io.Writer) error {
+		called = true
+		_, err := fmt.Fprint(w, "a,b,c")
+		return err
+	})
+
+	if len(m.attachments) != 1 {
+		t.Fatalf("attachments = %d, want 1", len(m.attachments))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := m.attachments[0].writeTo(buf); err != nil {
+		t.Fatalf("writeTo() error = %v", err)
+	}
+
+	if !called {
+		t.Error("AttachWithFunc() did not invoke fn")
+	}
+	if got := buf.String(); got != "a,b,c" {
+		t.Errorf("attachment content = %q, want %q", got, "a,b,c")
+	}
+	if got := m.attachments[0].name(); got != "report.csv" {
+		t.Errorf("attachment name = %q, want %q", got, "report.csv")
+	}
+	if got := m.attachments[0].mimeType(); got != "text/csv" {
+		t.Errorf("attachment mimeType = %q, want %q", got, "text/csv")
+	}
+}
+
+// benchAttachmentFile writes n bytes to a temporary file for use as an
+// attachment source in the MimeBuf/MimeStream benchmarks below, and returns
+// its path.
+func benchAttachmentFile(b *testing.B, n int) string {
+	b.Helper()
+
+	f, err := os.CreateTemp(b.TempDir(), "mailyak-bench-*.bin")
+	if err != nil {
+		b.Fatalf("CreateTemp() error = %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(bytes.Repeat([]byte("x"), n)); err != nil {
+		b.Fatalf("Write() error = %v", err)
+	}
+
+	return f.Name()
+}
+
+// BenchmarkMimeBuf_FileAttachment builds the message via MimeBuf, which
+// buffers the whole message, including the base64-encoded attachment, in
+// memory at once.
+func BenchmarkMimeBuf_FileAttachment(b *testing.B) {
+	path := benchAttachmentFile(b, 1<<20)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatalf("Open() error = %v", err)
+		}
+
+		m := New("", nil)
+		m.Attach("data.bin", f)
+
+		if _, err := m.MimeBuf(); err != nil {
+			b.Fatalf("MimeBuf() error = %v", err)
+		}
+		f.Close()
+	}
+}
+
+// BenchmarkMimeStream_FileAttachment builds the message via MimeStream into
+// io.Discard, streaming the attachment straight from disk through base64
+// encoding without ever buffering the full message, so peak allocations
+// stay flat as the attachment grows.
+func BenchmarkMimeStream_FileAttachment(b *testing.B) {
+	path := benchAttachmentFile(b, 1<<20)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatalf("Open() error = %v", err)
+		}
+
+		m := New("", nil)
+		m.Attach("data.bin", f)
+
+		if err := m.MimeStream(io.Discard); err != nil {
+			b.Fatalf("MimeStream() error = %v", err)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,405 @@
+package mailyak
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"unicode/utf8"
+)
+
+// Encoding is the Content-Transfer-Encoding used for the plain text and
+// HTML body parts.
+type Encoding string
+
+// Supported body part encodings.
+const (
+	// EncodingQuotedPrintable is the default, and is safe for any 7-bit SMTP
+	// relay while keeping non-ASCII bodies human readable on the wire.
+	EncodingQuotedPrintable Encoding = "quoted-printable"
+	Encoding7bit            Encoding = "7bit"
+	Encoding8bit            Encoding = "8bit"
+	EncodingBase64          Encoding = "base64"
+)
+
+// attachment is implemented by any source of content that can be embedded
+// as a base64-encoded MIME part of the outgoing message: a buffered
+// io.Reader, or a callback streaming content straight into the MIME writer.
+type attachment interface {
+	// name returns the filename used in the part's Content-Disposition.
+	name() string
+	// mimeType returns the Content-Type used for the part.
+	mimeType() string
+	// writeTo writes the attachment's raw (undecoded) content to w.
+	writeTo(w io.Writer) error
+}
+
+// readerAttachment is an attachment sourced from an io.Reader, which is not
+// read until the message is built.
+type readerAttachment struct {
+	filename    string
+	contentType string
+	reader      io.Reader
+}
+
+func (a readerAttachment) name() string { return a.filename }
+
+func (a readerAttachment) mimeType() string {
+	if a.contentType == "" {
+		return "application/octet-stream"
+	}
+	return a.contentType
+}
+
+func (a readerAttachment) writeTo(w io.Writer) error {
+	_, err := io.Copy(w, a.reader)
+	return err
+}
+
+// funcAttachment is an attachment whose content is generated by fn directly
+// into the MIME writer, so it never needs to be buffered in memory ahead of
+// time.
+type funcAttachment struct {
+	filename    string
+	contentType string
+	fn          func(io.Writer) error
+}
+
+func (a funcAttachment) name() string { return a.filename }
+
+func (a funcAttachment) mimeType() string {
+	if a.contentType == "" {
+		return "application/octet-stream"
+	}
+	return a.contentType
+}
+
+func (a funcAttachment) writeTo(w io.Writer) error {
+	return a.fn(w)
+}
+
+// inlineAttachment is an attachment embedded in the multipart/related
+// subtree wrapping the body, referenced from the HTML alternative via
+// "cid:<cid>".
+type inlineAttachment struct {
+	cid string
+	attachment
+}
+
+// fromHeader returns a fully formed "From" header, using the name and
+// address configured on m.
+func (m *MailYak) fromHeader() string {
+	if m.fromName == "" {
+		return fmt.Sprintf("From: %s\r\n", m.fromAddr)
+	}
+
+	return fmt.Sprintf("From: %s <%s>\r\n", encodeWord(m.fromName), m.fromAddr)
+}
+
+// writeHeaders writes the Cc, From, Mime-Version, Reply-To, Subject and To
+// headers to w. Bcc addresses are never written as a header.
+//
+// Subject, the From display name and To display names are RFC 2047
+// encoded-word encoded when they contain non-ASCII characters, so the
+// message remains safe for 7-bit SMTP relays and renders correctly in MUAs.
+func (m *MailYak) writeHeaders(w io.Writer) error {
+	for _, addr := range m.ccAddrs {
+		if _, err := fmt.Fprintf(w, "Cc: %s\r\n", addr); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(w, m.fromHeader()); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "Mime-Version: 1.0\r\n"); err != nil {
+		return err
+	}
+
+	if m.replyTo != "" {
+		if _, err := fmt.Fprintf(w, "Reply-To: %s\r\n", m.replyTo); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "Subject: %s\r\n", encodeWord(m.subject)); err != nil {
+		return err
+	}
+
+	for _, addr := range m.toAddrs {
+		if _, err := fmt.Fprintf(w, "To: %s\r\n", encodeAddressWord(addr)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeWord RFC 2047 encoded-word encodes s using UTF-8/base64 if it
+// contains any non-ASCII bytes, and returns it unmodified otherwise.
+func encodeWord(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return mime.BEncoding.Encode("UTF-8", s)
+		}
+	}
+
+	return s
+}
+
+// encodeAddressWord RFC 2047 encodes the display name of addr, a
+// "Name <address>" or bare address string, if the name contains non-ASCII
+// characters. addr is returned unmodified if it has no name, or fails to
+// parse as an RFC 5322 address.
+func encodeAddressWord(addr string) string {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil || parsed.Name == "" {
+		return addr
+	}
+
+	name := encodeWord(parsed.Name)
+	if name == parsed.Name {
+		return addr
+	}
+
+	return fmt.Sprintf("%s <%s>", name, parsed.Address)
+}
+
+// writeBody writes the plain text and HTML alternatives to w as parts of a
+// multipart/alternative body using boundary, encoded per m.textEncoding (see
+// SetEncoding).
+func (m *MailYak) writeBody(w io.Writer, boundary string) error {
+	mpw := multipart.NewWriter(w)
+	if err := mpw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	enc := m.textEncoding
+	if enc == "" {
+		enc = EncodingQuotedPrintable
+	}
+
+	if len(m.plain) > 0 {
+		pw, err := mpw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/plain; charset=UTF-8"},
+			"Content-Transfer-Encoding": {string(enc)},
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := writeEncoded(pw, m.plain, enc); err != nil {
+			return err
+		}
+	}
+
+	if len(m.html) > 0 {
+		hw, err := mpw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/html; charset=UTF-8"},
+			"Content-Transfer-Encoding": {string(enc)},
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := writeEncoded(hw, m.html, enc); err != nil {
+			return err
+		}
+	}
+
+	return mpw.Close()
+}
+
+// writeEncoded writes body to w, encoded per enc.
+func writeEncoded(w io.Writer, body []byte, enc Encoding) error {
+	switch enc {
+	case EncodingBase64:
+		b64 := base64.NewEncoder(base64.StdEncoding, newBase64LineWrapWriter(w))
+		if _, err := b64.Write(body); err != nil {
+			return err
+		}
+		return b64.Close()
+	case EncodingQuotedPrintable:
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(body); err != nil {
+			return err
+		}
+		return qp.Close()
+	default: // Encoding7bit, Encoding8bit: written verbatim.
+		_, err := w.Write(body)
+		return err
+	}
+}
+
+// writeAttachment writes a as a part of the multipart/mixed body using
+// boundary, base64 encoding the attachment content as it is streamed from
+// a.writeTo, without buffering it in memory.
+func (m *MailYak) writeAttachment(w io.Writer, boundary string, a attachment) error {
+	if _, err := fmt.Fprintf(w, "\r\n--%s\r\n", boundary); err != nil {
+		return err
+	}
+	disp := mime.FormatMediaType("attachment", map[string]string{"filename": a.name()})
+	if _, err := fmt.Fprintf(w, "Content-Disposition: %s\r\n", disp); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "Content-Transfer-Encoding: base64\r\n"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Type: %s\r\n\r\n", a.mimeType()); err != nil {
+		return err
+	}
+
+	return writeBase64(w, a.writeTo)
+}
+
+// writeInlineAttachment writes a as a part of the multipart/related body
+// using boundary, base64 encoding the content as it is streamed from
+// a.writeTo and identifying it with a Content-ID header so it can be
+// referenced from the HTML alternative via "cid:<cid>".
+func (m *MailYak) writeInlineAttachment(w io.Writer, boundary string, a inlineAttachment) error {
+	if _, err := fmt.Fprintf(w, "\r\n--%s\r\n", boundary); err != nil {
+		return err
+	}
+	disp := mime.FormatMediaType("inline", map[string]string{"filename": a.name()})
+	if _, err := fmt.Fprintf(w, "Content-Disposition: %s\r\n", disp); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-ID: <%s>\r\n", a.cid); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "Content-Transfer-Encoding: base64\r\n"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Type: %s\r\n\r\n", a.mimeType()); err != nil {
+		return err
+	}
+
+	return writeBase64(w, a.writeTo)
+}
+
+// writeBase64 base64-encodes the content written by write into w, folding
+// output lines at base64LineLength per RFC 2045 section 6.8.
+func writeBase64(w io.Writer, write func(io.Writer) error) error {
+	enc := base64.NewEncoder(base64.StdEncoding, newBase64LineWrapWriter(w))
+	if err := write(enc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// base64LineLength is the maximum line length, per RFC 2045 section 6.8,
+// for base64-encoded body parts.
+const base64LineLength = 76
+
+// base64LineWrapWriter inserts a CRLF into the underlying writer every
+// base64LineLength bytes written, without ever emitting a trailing CRLF
+// after the last full line.
+type base64LineWrapWriter struct {
+	w   io.Writer
+	col int
+}
+
+func newBase64LineWrapWriter(w io.Writer) *base64LineWrapWriter {
+	return &base64LineWrapWriter{w: w}
+}
+
+func (lw *base64LineWrapWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if lw.col == base64LineLength {
+			if _, err := lw.w.Write([]byte("\r\n")); err != nil {
+				return written, err
+			}
+			lw.col = 0
+		}
+
+		n := base64LineLength - lw.col
+		if n > len(p) {
+			n = len(p)
+		}
+
+		if _, err := lw.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+
+		written += n
+		lw.col += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// buildMimeWithBoundaries assembles the full MIME message into w using the
+// given mixed, related and alternative boundaries, allowing tests to use
+// predictable boundary strings. MimeStream is the entry point used outside
+// of tests.
+//
+// The structure is:
+//
+//	multipart/mixed (mixedBoundary)
+//	├── multipart/related (relatedBoundary)  [only when inline parts exist]
+//	│   ├── multipart/alternative (altBoundary)
+//	│   │   ├── text/plain
+//	│   │   └── text/html
+//	│   └── inline parts (images, referenced by the HTML as cid:...)
+//	└── attachments
+//
+// Attachment and inline attachment content is streamed straight into w as
+// it is produced by their writeTo methods, so it is never buffered in
+// memory ahead of time.
+func (m *MailYak) buildMimeWithBoundaries(w io.Writer, mixedBoundary, relatedBoundary, altBoundary string) error {
+	if err := m.writeHeaders(w); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "Content-Type: multipart/mixed;\r\n\tboundary=\"%s\"; charset=UTF-8\r\n\r\n", mixedBoundary); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "--%s\r\n", mixedBoundary); err != nil {
+		return err
+	}
+
+	hasInlines := len(m.inlines) > 0
+	if hasInlines {
+		if _, err := fmt.Fprintf(w, "Content-Type: multipart/related;\r\n\tboundary=\"%s\"\r\n\r\n", relatedBoundary); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "--%s\r\n", relatedBoundary); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "Content-Type: multipart/alternative;\r\n\tboundary=\"%s\"\r\n\r\n", altBoundary); err != nil {
+		return err
+	}
+
+	if err := m.writeBody(w, altBoundary); err != nil {
+		return err
+	}
+
+	if hasInlines {
+		for _, a := range m.inlines {
+			if err := m.writeInlineAttachment(w, relatedBoundary, a); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "\r\n--%s--\r\n", relatedBoundary); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range m.attachments {
+		if err := m.writeAttachment(w, mixedBoundary, a); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "\r\n--%s--\r\n", mixedBoundary)
+	return err
+}
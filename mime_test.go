@@ -5,12 +5,53 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"mime/multipart"
 	"regexp"
 	"strings"
 	"testing"
 )
 
+// TestBase64LineWrapWriter ensures output is folded at base64LineLength
+// bytes per line with CRLF, and never ends in a trailing CRLF.
+func TestBase64LineWrapWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{"shorter than one line", 10},
+		{"exactly one line", base64LineLength},
+		{"just over one line", base64LineLength + 1},
+		{"several lines", base64LineLength*3 + 20},
+	}
+
+	for _, tt := range tests {
+		in := bytes.Repeat([]byte("A"), tt.n)
+
+		got := &bytes.Buffer{}
+		lw := newBase64LineWrapWriter(got)
+		if _, err := lw.Write(in); err != nil {
+			t.Fatalf("%q. Write() error = %v", tt.name, err)
+		}
+
+		lines := strings.Split(got.String(), "\r\n")
+		for i, l := range lines {
+			if len(l) > base64LineLength {
+				t.Errorf("%q. line %d is %d bytes, want <= %d", tt.name, i, len(l), base64LineLength)
+			}
+			if i < len(lines)-1 && len(l) != base64LineLength {
+				t.Errorf("%q. line %d is %d bytes, want exactly %d (only the last line may be shorter)", tt.name, i, len(l), base64LineLength)
+			}
+		}
+		if strings.HasSuffix(got.String(), "\r\n") {
+			t.Errorf("%q. output ends in a trailing CRLF: %q", tt.name, got.String())
+		}
+		if got.Len() != tt.n+2*(len(lines)-1) {
+			t.Errorf("%q. output length = %d, want %d (input plus CRLFs)", tt.name, got.Len(), tt.n+2*(len(lines)-1))
+		}
+	}
+}
+
 // TestMailYakFromHeader ensures the fromHeader method returns valid headers
 func TestMailYakFromHeader(t *testing.T) {
 	tests := []struct {
@@ -40,6 +81,12 @@ func TestMailYakFromHeader(t *testing.T) {
 			"",
 			"From: \r\n",
 		},
+		{
+			"Non-ASCII name",
+			"dom@itsallbroken.com",
+			"Dömenico",
+			"From: =?UTF-8?b?RMO2bWVuaWNv?= <dom@itsallbroken.com>\r\n",
+		},
 	}
 	for _, tt := range tests {
 		m := MailYak{
@@ -53,14 +100,15 @@ func TestMailYakFromHeader(t *testing.T) {
 	}
 }
 
-// TestMailYakWriteHeaders ensures the Mime-Version, Reply-To, From, To and
-// Subject headers are correctly wrote
+// TestMailYakWriteHeaders ensures the Cc, Mime-Version, Reply-To, From, To
+// and Subject headers are correctly wrote
 func TestMailYakWriteHeaders(t *testing.T) {
 	tests := []struct {
 		// Test description.
 		name string
 		// Receiver fields.
 		rtoAddrs []string
+		rccAddrs []string
 		rsubject string
 		rreplyTo string
 		// Expected results.
@@ -69,6 +117,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 		{
 			"All fields",
 			[]string{"test@itsallbroken.com"},
+			nil,
 			"Test",
 			"help@itsallbroken.com",
 			"From: Dom <dom@itsallbroken.com>\r\nMime-Version: 1.0\r\nReply-To: help@itsallbroken.com\r\nSubject: Test\r\nTo: test@itsallbroken.com\r\n",
@@ -76,6 +125,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 		{
 			"No reply-to",
 			[]string{"test@itsallbroken.com"},
+			nil,
 			"",
 			"",
 			"From: Dom <dom@itsallbroken.com>\r\nMime-Version: 1.0\r\nSubject: \r\nTo: test@itsallbroken.com\r\n",
@@ -83,14 +133,40 @@ func TestMailYakWriteHeaders(t *testing.T) {
 		{
 			"Multiple To addresses",
 			[]string{"test@itsallbroken.com", "repairs@itsallbroken.com"},
+			nil,
 			"",
 			"",
 			"From: Dom <dom@itsallbroken.com>\r\nMime-Version: 1.0\r\nSubject: \r\nTo: test@itsallbroken.com\r\nTo: repairs@itsallbroken.com\r\n",
 		},
+		{
+			"Cc addresses",
+			[]string{"test@itsallbroken.com"},
+			[]string{"cc1@itsallbroken.com", "cc2@itsallbroken.com"},
+			"",
+			"",
+			"Cc: cc1@itsallbroken.com\r\nCc: cc2@itsallbroken.com\r\nFrom: Dom <dom@itsallbroken.com>\r\nMime-Version: 1.0\r\nSubject: \r\nTo: test@itsallbroken.com\r\n",
+		},
+		{
+			"Non-ASCII subject",
+			[]string{"test@itsallbroken.com"},
+			nil,
+			"Tëst",
+			"",
+			"From: Dom <dom@itsallbroken.com>\r\nMime-Version: 1.0\r\nSubject: =?UTF-8?b?VMOrc3Q=?=\r\nTo: test@itsallbroken.com\r\n",
+		},
+		{
+			"Non-ASCII To display name",
+			[]string{"Jané <test@itsallbroken.com>"},
+			nil,
+			"",
+			"",
+			"From: Dom <dom@itsallbroken.com>\r\nMime-Version: 1.0\r\nSubject: \r\nTo: =?UTF-8?b?SmFuw6k=?= <test@itsallbroken.com>\r\n",
+		},
 	}
 	for _, tt := range tests {
 		m := MailYak{
 			toAddrs:  tt.rtoAddrs,
+			ccAddrs:  tt.rccAddrs,
 			subject:  tt.rsubject,
 			fromAddr: "dom@itsallbroken.com",
 			fromName: "Dom",
@@ -133,7 +209,7 @@ func TestMailYakWriteBody(t *testing.T) {
 			"HTML",
 			"",
 			"t",
-			"--t\r\nContent-Type: text/html; charset=UTF-8\r\n\r\nHTML\r\n--t--\r\n",
+			"--t\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/html; charset=UTF-8\r\n\r\nHTML\r\n--t--\r\n",
 			false,
 		},
 		{
@@ -141,7 +217,7 @@ func TestMailYakWriteBody(t *testing.T) {
 			"",
 			"Plain",
 			"t",
-			"--t\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--t--\r\n",
+			"--t\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--t--\r\n",
 			false,
 		},
 		{
@@ -149,7 +225,7 @@ func TestMailYakWriteBody(t *testing.T) {
 			"HTML",
 			"Plain",
 			"t",
-			"--t\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--t\r\nContent-Type: text/html; charset=UTF-8\r\n\r\nHTML\r\n--t--\r\n",
+			"--t\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--t\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/html; charset=UTF-8\r\n\r\nHTML\r\n--t--\r\n",
 			false,
 		},
 	}
@@ -171,6 +247,43 @@ func TestMailYakWriteBody(t *testing.T) {
 	}
 }
 
+// TestMailYakWriteBody_textEncoding ensures writeBody honours m.textEncoding,
+// set via SetEncoding.
+func TestMailYakWriteBody_textEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		enc  Encoding
+		want string
+	}{
+		{
+			"base64",
+			EncodingBase64,
+			"--t\r\nContent-Transfer-Encoding: base64\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nUGxhaW4=\r\n--t--\r\n",
+		},
+		{
+			"7bit",
+			Encoding7bit,
+			"--t\r\nContent-Transfer-Encoding: 7bit\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--t--\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		m := MailYak{
+			plain:        []byte("Plain"),
+			textEncoding: tt.enc,
+		}
+
+		w := &bytes.Buffer{}
+		if err := m.writeBody(w, "t"); err != nil {
+			t.Fatalf("%q. MailYak.writeBody() error = %v", tt.name, err)
+		}
+
+		if got := w.String(); got != tt.want {
+			t.Errorf("%q. MailYak.writeBody() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
 // TestMailYakBuildMime tests all the other mime-related bits combine in a sane way
 func TestMailYakBuildMime(t *testing.T) {
 	tests := []struct {
@@ -209,7 +322,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"",
-			"From: \r\nMime-Version: 1.0\r\nSubject: \r\nTo: \r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n--alt\r\nContent-Type: text/html; charset=UTF-8\r\n\r\nHTML\r\n--alt--\r\n\r\n--mixed--\r\n",
+			"From: \r\nMime-Version: 1.0\r\nSubject: \r\nTo: \r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n--alt\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/html; charset=UTF-8\r\n\r\nHTML\r\n--alt--\r\n\r\n--mixed--\r\n",
 			false,
 		},
 		{
@@ -221,7 +334,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"",
-			"From: \r\nMime-Version: 1.0\r\nSubject: \r\nTo: \r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n--alt\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--alt--\r\n\r\n--mixed--\r\n",
+			"From: \r\nMime-Version: 1.0\r\nSubject: \r\nTo: \r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n--alt\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--alt--\r\n\r\n--mixed--\r\n",
 			false,
 		},
 		{
@@ -312,7 +425,8 @@ func TestMailYakBuildMime(t *testing.T) {
 			trimRegex: regex,
 		}
 
-		got, err := m.buildMimeWithBoundaries("mixed", "alt")
+		got := &bytes.Buffer{}
+		err := m.buildMimeWithBoundaries(got, "mixed", "related", "alt")
 		if (err != nil) != tt.wantErr {
 			t.Errorf("%q. MailYak.buildMime() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			continue
@@ -337,7 +451,7 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 		rfromAddr    string
 		rfromName    string
 		rreplyTo     string
-		rattachments []attachment
+		rattachments []readerAttachment
 		// Expected results.
 		wantAttach []string
 		wantErr    bool
@@ -351,7 +465,7 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			"",
 			"",
 			"",
-			[]attachment{},
+			[]readerAttachment{},
 			[]string{},
 			false,
 		},
@@ -364,8 +478,8 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			"",
 			"",
 			"",
-			[]attachment{
-				{"test.txt", strings.NewReader("content")},
+			[]readerAttachment{
+				{filename: "test.txt", reader: strings.NewReader("content")},
 			},
 			[]string{"Y29udGVudA=="},
 			false,
@@ -379,9 +493,9 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			"",
 			"",
 			"",
-			[]attachment{
-				{"test.txt", strings.NewReader("content")},
-				{"another.txt", strings.NewReader("another")},
+			[]readerAttachment{
+				{filename: "test.txt", reader: strings.NewReader("content")},
+				{filename: "another.txt", reader: strings.NewReader("another")},
 			},
 			[]string{"Y29udGVudA==", "YW5vdGhlcg=="},
 			false,
@@ -391,6 +505,11 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 	regex := regexp.MustCompile("\r?\n")
 
 	for _, tt := range tests {
+		attachments := make([]attachment, len(tt.rattachments))
+		for i, a := range tt.rattachments {
+			attachments[i] = a
+		}
+
 		m := &MailYak{
 			html:        tt.rHTML,
 			plain:       tt.rPlain,
@@ -399,11 +518,12 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			fromAddr:    tt.rfromAddr,
 			fromName:    tt.rfromName,
 			replyTo:     tt.rreplyTo,
-			attachments: tt.rattachments,
+			attachments: attachments,
 			trimRegex:   regex,
 		}
 
-		got, err := m.buildMimeWithBoundaries("mixed", "alt")
+		got := &bytes.Buffer{}
+		err := m.buildMimeWithBoundaries(got, "mixed", "related", "alt")
 		if (err != nil) != tt.wantErr {
 			t.Errorf("%q. MailYak.buildMime() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			continue
@@ -457,3 +577,95 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 		}
 	}
 }
+
+// TestMailYakBuildMime_withInlineAttachments ensures inline attachments are
+// wrapped in a multipart/related subtree nested inside the outer
+// multipart/mixed, with the multipart/alternative body nested inside that -
+// the mixed -> related -> alternative ordering required for Gmail/Outlook
+// to resolve cid: references.
+func TestMailYakBuildMime_withInlineAttachments(t *testing.T) {
+	regex := regexp.MustCompile("\r?\n")
+
+	m := &MailYak{
+		html:  []byte("<img src=\"cid:logo\">"),
+		plain: []byte("Plain"),
+		inlines: []inlineAttachment{
+			{cid: "logo", attachment: readerAttachment{filename: "logo.png", reader: strings.NewReader("logo-bytes")}},
+		},
+		attachments: []attachment{
+			readerAttachment{filename: "report.pdf", reader: strings.NewReader("pdf-bytes")},
+		},
+		trimRegex: regex,
+	}
+
+	got := &bytes.Buffer{}
+	if err := m.buildMimeWithBoundaries(got, "mixed", "related", "alt"); err != nil {
+		t.Fatalf("MailYak.buildMimeWithBoundaries() error = %v", err)
+	}
+	raw := got.String()
+
+	// The related part must be nested inside mixed, and the alternative
+	// part must be nested inside related.
+	mixedIdx := strings.Index(raw, "Content-Type: multipart/mixed")
+	relatedIdx := strings.Index(raw, "Content-Type: multipart/related")
+	altIdx := strings.Index(raw, "Content-Type: multipart/alternative")
+	if mixedIdx == -1 || relatedIdx == -1 || altIdx == -1 {
+		t.Fatalf("expected mixed, related and alternative parts, got:\n%s", raw)
+	}
+	if !(mixedIdx < relatedIdx && relatedIdx < altIdx) {
+		t.Errorf("expected boundary order mixed < related < alternative, got offsets %d, %d, %d", mixedIdx, relatedIdx, altIdx)
+	}
+
+	mr := multipart.NewReader(got, "mixed")
+	var sawInline, sawAttachment bool
+
+	var walk func(r *multipart.Reader) error
+	walk = func(r *multipart.Reader) error {
+		for {
+			p, err := r.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			mediaType, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			if err == nil && strings.HasPrefix(mediaType, "multipart/") {
+				if err := walk(multipart.NewReader(p, params["boundary"])); err != nil {
+					return err
+				}
+				continue
+			}
+
+			slurp, err := ioutil.ReadAll(p)
+			if err != nil {
+				return err
+			}
+
+			switch p.Header.Get("Content-Disposition") {
+			case "inline; filename=logo.png":
+				sawInline = true
+				if p.Header.Get("Content-ID") != "<logo>" {
+					t.Errorf("inline Content-ID = %q, want %q", p.Header.Get("Content-ID"), "<logo>")
+				}
+				if string(slurp) != "bG9nby1ieXRlcw==" {
+					t.Errorf("inline content = %q, want %q", slurp, "bG9nby1ieXRlcw==")
+				}
+			case "attachment; filename=report.pdf":
+				sawAttachment = true
+			}
+		}
+	}
+
+	if err := walk(mr); err != nil {
+		t.Fatalf("walking mime parts: %v", err)
+	}
+
+	if !sawInline {
+		t.Error("did not find inline attachment part")
+	}
+	if !sawAttachment {
+		t.Error("did not find regular attachment part")
+	}
+}
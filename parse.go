@@ -0,0 +1,187 @@
+package mailyak
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// ParseEML parses a raw RFC 5322 message read from r, returning a MailYak
+// populated with its From/To/Cc/Reply-To/Subject headers, HTML and plain-text
+// alternatives, and attachments (including inline parts identified by
+// Content-Disposition: inline and a Content-ID).
+//
+// ParseEML is the inverse of buildMimeWithBoundaries: given the output of
+// m.MimeBuf(), it produces an equivalent MailYak. The returned MailYak has
+// no host or auth configured, so callers wanting to resend the parsed
+// message must set those separately.
+func ParseEML(r io.Reader) (*MailYak, error) {
+	msg, err := mail.ReadMessage(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("mailyak: parsing message: %w", err)
+	}
+
+	m := New("", nil)
+	m.parseHeaders(msg.Header)
+
+	if err := m.parseBody(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ParseEMLString is a convenience wrapper around ParseEML for callers that
+// already have the message in memory.
+func ParseEMLString(s string) (*MailYak, error) {
+	return ParseEML(strings.NewReader(s))
+}
+
+// ParseEMLFile is a convenience wrapper around ParseEML that reads the
+// message from the file at path.
+func ParseEMLFile(path string) (*MailYak, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("mailyak: opening %q: %w", path, err)
+	}
+	defer f.Close()
+
+	return ParseEML(f)
+}
+
+// parseHeaders populates the From, To, Cc, Reply-To and Subject fields of m
+// from the RFC 5322 headers in h, RFC 2047-decoding encoded words as needed.
+// Bcc cannot be recovered, as mailyak never writes a Bcc header.
+func (m *MailYak) parseHeaders(h mail.Header) {
+	if addrs, err := h.AddressList("From"); err == nil && len(addrs) > 0 {
+		m.fromAddr = addrs[0].Address
+		m.fromName = addrs[0].Name
+	}
+
+	if vals, ok := h["To"]; ok {
+		if addrs, err := mail.ParseAddressList(strings.Join(vals, ", ")); err == nil {
+			for _, a := range addrs {
+				addr := a.Address
+				if a.Name != "" {
+					addr = fmt.Sprintf("%s <%s>", a.Name, a.Address)
+				}
+				m.toAddrs = append(m.toAddrs, addr)
+			}
+		}
+	}
+
+	if vals, ok := h["Cc"]; ok {
+		if addrs, err := mail.ParseAddressList(strings.Join(vals, ", ")); err == nil {
+			for _, a := range addrs {
+				addr := a.Address
+				if a.Name != "" {
+					addr = fmt.Sprintf("%s <%s>", a.Name, a.Address)
+				}
+				m.ccAddrs = append(m.ccAddrs, addr)
+			}
+		}
+	}
+
+	if addrs, err := h.AddressList("Reply-To"); err == nil && len(addrs) > 0 {
+		m.replyTo = addrs[0].Address
+	}
+
+	dec := new(mime.WordDecoder)
+	if subj, err := dec.DecodeHeader(h.Get("Subject")); err == nil {
+		m.subject = subj
+	} else {
+		m.subject = h.Get("Subject")
+	}
+}
+
+// parseBody walks a MIME body part, recursing into multipart/* trees and
+// populating m's HTML/plain alternatives and attachments as leaf parts are
+// found. header carries the part's own headers (Content-Type,
+// Content-Transfer-Encoding, Content-Disposition).
+func (m *MailYak) parseBody(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("mailyak: reading part: %w", err)
+			}
+
+			if err := m.parseBody(textproto.MIMEHeader(p.Header), p); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	decoded, err := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return fmt.Errorf("mailyak: decoding part: %w", err)
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+
+	if disposition != "attachment" && disposition != "inline" {
+		switch mediaType {
+		case "text/plain":
+			m.plain = decoded
+			return nil
+		case "text/html":
+			m.html = decoded
+			return nil
+		}
+	}
+
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	ra := readerAttachment{
+		filename:    filename,
+		contentType: mediaType,
+		reader:      bytes.NewReader(decoded),
+	}
+
+	if disposition == "inline" {
+		cid := strings.Trim(header.Get("Content-ID"), "<>")
+		m.inlines = append(m.inlines, inlineAttachment{cid: cid, attachment: ra})
+		return nil
+	}
+
+	m.attachments = append(m.attachments, ra)
+
+	return nil
+}
+
+// decodeTransferEncoding decodes r per the Content-Transfer-Encoding value
+// cte, defaulting to a verbatim copy for empty, "7bit", "8bit" and "binary".
+func decodeTransferEncoding(cte string, r io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, r))
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(r))
+	default:
+		return ioutil.ReadAll(r)
+	}
+}
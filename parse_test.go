@@ -0,0 +1,230 @@
+package mailyak
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// TestParseEML_RoundTrip ensures a MailYak built with To/From/Subject/HTML/
+// Plain/Attach round-trips through MimeBuf -> ParseEML with an equivalent
+// result.
+func TestParseEML_RoundTrip(t *testing.T) {
+	orig := New("", nil)
+	orig.From("dom@itsallbroken.com")
+	orig.FromName("Dom")
+	orig.To("test@itsallbroken.com", "repairs@itsallbroken.com")
+	orig.Cc("cc@itsallbroken.com")
+	orig.ReplyTo("help@itsallbroken.com")
+	orig.Subject("Test")
+	orig.HTML().Set("<h1>HTML</h1>")
+	orig.Plain().Set("Plain")
+	orig.Attach("test.txt", strings.NewReader("content"))
+
+	buf, err := orig.MimeBuf()
+	if err != nil {
+		t.Fatalf("MimeBuf() error = %v", err)
+	}
+
+	got, err := ParseEML(buf)
+	if err != nil {
+		t.Fatalf("ParseEML() error = %v", err)
+	}
+
+	if got.fromAddr != orig.fromAddr {
+		t.Errorf("fromAddr = %q, want %q", got.fromAddr, orig.fromAddr)
+	}
+	if got.fromName != orig.fromName {
+		t.Errorf("fromName = %q, want %q", got.fromName, orig.fromName)
+	}
+	if got.subject != orig.subject {
+		t.Errorf("subject = %q, want %q", got.subject, orig.subject)
+	}
+	if got.replyTo != orig.replyTo {
+		t.Errorf("replyTo = %q, want %q", got.replyTo, orig.replyTo)
+	}
+	if len(got.toAddrs) != len(orig.toAddrs) {
+		t.Fatalf("toAddrs = %v, want %v", got.toAddrs, orig.toAddrs)
+	}
+	for i := range orig.toAddrs {
+		if got.toAddrs[i] != orig.toAddrs[i] {
+			t.Errorf("toAddrs[%d] = %q, want %q", i, got.toAddrs[i], orig.toAddrs[i])
+		}
+	}
+	if len(got.ccAddrs) != 1 || got.ccAddrs[0] != "cc@itsallbroken.com" {
+		t.Errorf("ccAddrs = %v, want %v", got.ccAddrs, orig.ccAddrs)
+	}
+	if string(got.html) != string(orig.html) {
+		t.Errorf("html = %q, want %q", got.html, orig.html)
+	}
+	if string(got.plain) != string(orig.plain) {
+		t.Errorf("plain = %q, want %q", got.plain, orig.plain)
+	}
+
+	if len(got.attachments) != 1 {
+		t.Fatalf("attachments = %d, want 1", len(got.attachments))
+	}
+	if got.attachments[0].name() != "test.txt" {
+		t.Errorf("attachment filename = %q, want %q", got.attachments[0].name(), "test.txt")
+	}
+	attachBuf := &bytes.Buffer{}
+	if err := got.attachments[0].writeTo(attachBuf); err != nil {
+		t.Fatalf("attachment writeTo() error = %v", err)
+	}
+	if attachBuf.String() != "content" {
+		t.Errorf("attachment content = %q, want %q", attachBuf.String(), "content")
+	}
+}
+
+// TestParseEML_RoundTrip_FilenameWithSpecials ensures attachment filenames
+// containing spaces and RFC 2045 tspecials (parentheses) are quoted on write and
+// recovered intact on parse.
+func TestParseEML_RoundTrip_FilenameWithSpecials(t *testing.T) {
+	orig := New("", nil)
+	orig.From("dom@itsallbroken.com")
+	orig.Attach("Invoice March.pdf", strings.NewReader("pdf-bytes"))
+	orig.AttachInline("logo", "Company Logo (v2).png", strings.NewReader("logo-bytes"))
+
+	buf, err := orig.MimeBuf()
+	if err != nil {
+		t.Fatalf("MimeBuf() error = %v", err)
+	}
+
+	got, err := ParseEML(buf)
+	if err != nil {
+		t.Fatalf("ParseEML() error = %v", err)
+	}
+
+	if len(got.attachments) != 1 || got.attachments[0].name() != "Invoice March.pdf" {
+		t.Fatalf("attachments = %v, want filename %q", got.attachments, "Invoice March.pdf")
+	}
+	if len(got.inlines) != 1 || got.inlines[0].name() != "Company Logo (v2).png" {
+		t.Fatalf("inlines = %v, want filename %q", got.inlines, "Company Logo (v2).png")
+	}
+}
+
+// TestParseEML_RoundTrip_LargeAttachment ensures an attachment whose
+// base64 encoding spans many folded lines still round-trips intact.
+func TestParseEML_RoundTrip_LargeAttachment(t *testing.T) {
+	content := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog, "), 500)
+
+	orig := New("", nil)
+	orig.From("dom@itsallbroken.com")
+	orig.Attach("large.bin", bytes.NewReader(content))
+
+	buf, err := orig.MimeBuf()
+	if err != nil {
+		t.Fatalf("MimeBuf() error = %v", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("mail.ReadMessage() error = %v", err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parsing boundary: %v", err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var p *multipart.Part
+	for {
+		p, err = mr.NextPart()
+		if err != nil {
+			t.Fatalf("NextPart() error = %v", err)
+		}
+		// Skip the (empty) multipart/alternative body to reach the attachment.
+		if p.Header.Get("Content-Disposition") != "" {
+			break
+		}
+	}
+	body, err := ioutil.ReadAll(p)
+	if err != nil {
+		t.Fatalf("reading part body: %v", err)
+	}
+	for _, line := range strings.Split(string(body), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("base64 line exceeds 76 characters (%d): %q", len(line), line)
+		}
+	}
+
+	got, err := ParseEML(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ParseEML() error = %v", err)
+	}
+
+	if len(got.attachments) != 1 {
+		t.Fatalf("attachments = %d, want 1", len(got.attachments))
+	}
+
+	attachBuf := &bytes.Buffer{}
+	if err := got.attachments[0].writeTo(attachBuf); err != nil {
+		t.Fatalf("attachment writeTo() error = %v", err)
+	}
+	if !bytes.Equal(attachBuf.Bytes(), content) {
+		t.Errorf("attachment content mismatch: got %d bytes, want %d bytes", attachBuf.Len(), len(content))
+	}
+}
+
+// TestParseEML_Inline ensures inline parts nested in a multipart/related
+// subtree are parsed into m.inlines rather than m.attachments.
+func TestParseEML_Inline(t *testing.T) {
+	orig := New("", nil)
+	orig.HTML().Set("<img src=\"cid:logo\">")
+	orig.AttachInline("logo", "logo.png", strings.NewReader("logo-bytes"))
+	orig.Attach("report.pdf", strings.NewReader("pdf-bytes"))
+
+	buf, err := orig.MimeBuf()
+	if err != nil {
+		t.Fatalf("MimeBuf() error = %v", err)
+	}
+
+	got, err := ParseEML(buf)
+	if err != nil {
+		t.Fatalf("ParseEML() error = %v", err)
+	}
+
+	if len(got.inlines) != 1 {
+		t.Fatalf("inlines = %d, want 1", len(got.inlines))
+	}
+	if got.inlines[0].cid != "logo" {
+		t.Errorf("inline cid = %q, want %q", got.inlines[0].cid, "logo")
+	}
+	if got.inlines[0].name() != "logo.png" {
+		t.Errorf("inline filename = %q, want %q", got.inlines[0].name(), "logo.png")
+	}
+
+	if len(got.attachments) != 1 {
+		t.Fatalf("attachments = %d, want 1", len(got.attachments))
+	}
+	if got.attachments[0].name() != "report.pdf" {
+		t.Errorf("attachment filename = %q, want %q", got.attachments[0].name(), "report.pdf")
+	}
+}
+
+// TestParseEMLString ensures ParseEMLString parses a minimal message.
+func TestParseEMLString(t *testing.T) {
+	raw := "From: dom@itsallbroken.com\r\n" +
+		"To: test@itsallbroken.com\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"Hi there"
+
+	m, err := ParseEMLString(raw)
+	if err != nil {
+		t.Fatalf("ParseEMLString() error = %v", err)
+	}
+
+	if m.fromAddr != "dom@itsallbroken.com" {
+		t.Errorf("fromAddr = %q, want %q", m.fromAddr, "dom@itsallbroken.com")
+	}
+	if m.subject != "Hello" {
+		t.Errorf("subject = %q, want %q", m.subject, "Hello")
+	}
+	if string(m.plain) != "Hi there" {
+		t.Errorf("plain = %q, want %q", m.plain, "Hi there")
+	}
+}